@@ -0,0 +1,310 @@
+package roles
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-paxos/domain"
+	"github.com/go-paxos/logger"
+	"github.com/tryfix/log"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	errUnknownChangeFunc   = `change function is not registered`
+	errCASDeadlineExceeded = `deadline exceeded while retrying a CAS proposal`
+)
+
+// changeFunc is a client-supplied state transition: given the register's current value it returns the next one
+type changeFunc func(state string, args json.RawMessage) (string, error)
+
+var (
+	changeFuncsLock sync.RWMutex
+	changeFuncs     = make(map[string]changeFunc)
+)
+
+// RegisterChangeFunc makes fn available to CASProposer.Change under name, so that clients can refer to it by name
+// over HTTP instead of serializing a closure
+func RegisterChangeFunc(name string, fn changeFunc) {
+	changeFuncsLock.Lock()
+	defer changeFuncsLock.Unlock()
+	changeFuncs[name] = fn
+}
+
+func lookupChangeFunc(name string) (changeFunc, bool) {
+	changeFuncsLock.RLock()
+	defer changeFuncsLock.RUnlock()
+	fn, ok := changeFuncs[name]
+	return fn, ok
+}
+
+// casRegister is the (ballot, state) pair an acceptor keeps for a single CASProposer register key. Unlike the
+// slot log in Leader, there's only ever one of these per key: CASPaxos has no ordered history to replay, just the
+// latest agreed value.
+type casRegister struct {
+	promisedID int
+	acceptedID int
+	state      string
+	exists     bool
+}
+
+// CASProposer implements CASPaxos: instead of proposing a raw value for a slot, a caller submits a named change
+// function and CASProposer reads the register's current value via Phase 1, applies the function locally, then
+// writes the result via Phase 2. The same struct plays both proposer and acceptor, mirroring Leader.
+type CASProposer struct {
+	id int
+
+	ballot ballot
+
+	registers map[string]*casRegister
+
+	acceptors []string // other CASProposers, excluding this one
+	client    *http.Client
+	lock      sync.Mutex
+	logger    log.Logger
+
+	PrepareTimeout time.Duration
+	AcceptTimeout  time.Duration
+}
+
+// NewCASProposer wires up a CASProposer that reaches acceptors at the given addresses over HTTP.
+func NewCASProposer(id int, acceptors []string, client *http.Client) *CASProposer {
+	return &CASProposer{
+		id:             id,
+		acceptors:      acceptors,
+		client:         client,
+		registers:      make(map[string]*casRegister),
+		PrepareTimeout: defaultPrepareTimeout,
+		AcceptTimeout:  defaultAcceptTimeout,
+	}
+}
+
+// Change applies the named, registered change function to key's current value and returns the resulting value
+// once a quorum has accepted it (acceptors keep registers in memory only). On a ballot conflict it bumps its ballot and retries with a fresh read
+// of the register, until deadline passes (a zero deadline means retry indefinitely).
+func (p *CASProposer) Change(key, funcName string, args json.RawMessage, deadline time.Time) (string, error) {
+	fn, ok := lookupChangeFunc(funcName)
+	if !ok {
+		return "", logger.ErrorWithLine(errors.New(fmt.Sprintf(`%s (func: %s)`, errUnknownChangeFunc, funcName)))
+	}
+
+	for {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return "", logger.ErrorWithLine(errors.New(fmt.Sprintf(`%s (key: %s)`, errCASDeadlineExceeded, key)))
+		}
+
+		p.lock.Lock()
+		p.ballot = ballot{round: p.ballot.round + 1, leaderID: p.id}
+		pID, err := p.ballot.encode()
+		p.lock.Unlock()
+		if err != nil {
+			return "", logger.ErrorWithLine(err)
+		}
+
+		prepCtx, cancel := context.WithTimeout(context.Background(), p.PrepareTimeout)
+		resList, err := p.send(prepCtx, typePrepare, domain.Proposal{ID: pID, Key: key, Change: &domain.Change{Func: funcName, Args: args}})
+		cancel()
+		if err != nil {
+			return "", logger.ErrorWithLine(err)
+		}
+
+		accepted, rejected, valid := validateCASPromises(resList)
+		if !valid || accepted <= rejected {
+			continue // lost the ballot race; retry with a fresh, higher ballot
+		}
+
+		next, err := fn(highestAcceptedValue(resList), args)
+		if err != nil {
+			return "", logger.ErrorWithLine(err)
+		}
+
+		acceptCtx, cancel := context.WithTimeout(context.Background(), p.AcceptTimeout)
+		resList, err = p.send(acceptCtx, typeAccept, domain.Proposal{ID: pID, Key: key, Val: next})
+		cancel()
+		if err != nil {
+			return "", logger.ErrorWithLine(err)
+		}
+
+		accepted, rejected = validateCASAccepts(resList)
+		if accepted <= rejected || accepted < len(p.acceptors)/2+1 {
+			continue // an acceptor promised a higher ballot meanwhile, or too few acceptors replied; retry
+		}
+
+		return next, nil
+	}
+}
+
+// highestAcceptedValue picks the value belonging to the highest-ballot accepted response in resList, or the
+// empty string if no acceptor in the quorum has ever accepted a value for this register yet
+func highestAcceptedValue(resList []domain.Acceptance) string {
+	var highestID int
+	var val string
+	for _, res := range resList {
+		if res.PrvAccept.Exists && res.PrvAccept.ID >= highestID {
+			highestID = res.PrvAccept.ID
+			val = res.PrvAccept.Val
+		}
+	}
+	return val
+}
+
+// validateCASPromises mirrors Leader.validatePromises: it counts accepted/rejected promises and reports false for
+// valid if some other proposer has already moved on to a higher ballot for this register
+func validateCASPromises(resList []domain.Acceptance) (accepted, rejected int, valid bool) {
+	for _, promise := range resList {
+		if promise.PrvPromise.Exists {
+			if promise.PrvPromise.ID >= promise.PID {
+				return accepted, rejected, false
+			}
+			rejected++
+			continue
+		}
+		accepted++
+	}
+	return accepted, rejected, true
+}
+
+// validateCASAccepts mirrors Leader.validateAccepts for the register's single accept round
+func validateCASAccepts(resList []domain.Acceptance) (accepted, rejected int) {
+	for _, res := range resList {
+		if res.Accepted {
+			accepted++
+			continue
+		}
+		rejected++
+	}
+	return accepted, rejected
+}
+
+// send fans the proposal out to every acceptor concurrently and returns as soon as a majority has replied,
+// the same quorum-early-return shape as Leader.send
+func (p *CASProposer) send(ctx context.Context, typ string, prop domain.Proposal) ([]domain.Acceptance, error) {
+	data, err := json.Marshal(prop)
+	if err != nil {
+		return nil, logger.ErrorWithLine(err)
+	}
+
+	endpoint := domain.CASPrepareEndpoint
+	if typ != typePrepare {
+		endpoint = domain.CASAcceptEndpoint
+	}
+
+	resCh := make(chan acceptorResult, len(p.acceptors))
+	for _, acceptor := range p.acceptors {
+		acceptor := acceptor
+		go func() {
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, `http://`+acceptor+endpoint, bytes.NewBuffer(data))
+			if err != nil {
+				resCh <- acceptorResult{err: logger.ErrorWithLine(err)}
+				return
+			}
+
+			res, err := p.client.Do(req)
+			if err != nil {
+				resCh <- acceptorResult{err: logger.ErrorWithLine(err)}
+				return
+			}
+			defer res.Body.Close()
+
+			if res.StatusCode != http.StatusOK {
+				resCh <- acceptorResult{err: logger.ErrorWithLine(errors.New(fmt.Sprintf(`%s (type: %s, status: %d)`, errRequestAcceptor, typ, res.StatusCode)))}
+				return
+			}
+
+			resData, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				resCh <- acceptorResult{err: logger.ErrorWithLine(err)}
+				return
+			}
+
+			var response domain.Acceptance
+			if err := json.Unmarshal(resData, &response); err != nil {
+				resCh <- acceptorResult{err: logger.ErrorWithLine(err)}
+				return
+			}
+			resCh <- acceptorResult{acceptances: []domain.Acceptance{response}}
+		}()
+	}
+
+	quorum := len(p.acceptors)/2 + 1
+	var resList []domain.Acceptance
+	for i := 0; i < len(p.acceptors); i++ {
+		select {
+		case r := <-resCh:
+			if r.err == nil {
+				resList = append(resList, r.acceptances...)
+				if len(resList) >= quorum {
+					return resList, nil
+				}
+			}
+		case <-ctx.Done():
+			return resList, nil
+		}
+	}
+
+	return resList, nil
+}
+
+// HandleCASPrepare is the acceptor side of Phase 1: it promises not to accept anything older than prop's ballot
+// for prop.Key and hands back the value it has accepted for that key so far, if any.
+func (p *CASProposer) HandleCASPrepare(prop domain.Proposal) (domain.Acceptance, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	reg, ok := p.registers[prop.Key]
+	if !ok {
+		reg = &casRegister{}
+		p.registers[prop.Key] = reg
+	}
+
+	var res domain.Acceptance
+	res.PID = prop.ID
+
+	if reg.promisedID >= prop.ID {
+		res.PrvPromise.Exists = true
+		res.PrvPromise.ID = reg.promisedID
+		return res, nil
+	}
+	reg.promisedID = prop.ID
+
+	if reg.exists {
+		res.PrvAccept.Exists = true
+		res.PrvAccept.ID = reg.acceptedID
+		res.PrvAccept.Val = reg.state
+	}
+
+	return res, nil
+}
+
+// HandleCASAccept is the acceptor side of Phase 2: it stores prop.Val as the new state for prop.Key, rejecting
+// the write if some other proposer has promised a higher ballot in the meantime.
+func (p *CASProposer) HandleCASAccept(prop domain.Proposal) (domain.Acceptance, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	reg, ok := p.registers[prop.Key]
+	if !ok {
+		reg = &casRegister{}
+		p.registers[prop.Key] = reg
+	}
+
+	var res domain.Acceptance
+	res.PID = prop.ID
+
+	if reg.promisedID > prop.ID {
+		res.Accepted = false
+		return res, nil
+	}
+
+	reg.acceptedID = prop.ID
+	reg.state = prop.Val
+	reg.exists = true
+	res.Accepted = true
+
+	return res, nil
+}
@@ -1,16 +1,13 @@
 package roles
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/go-paxos/domain"
 	"github.com/go-paxos/logger"
+	"github.com/go-paxos/transport"
 	"github.com/tryfix/log"
-	"io/ioutil"
-	"net/http"
-	"strconv"
 	"sync"
 	"time"
 )
@@ -19,174 +16,507 @@ const (
 	typePrepare = `prepare`
 	typeAccept  = `accept`
 
-	errInvalidSlotLeader = `leader received a request for an invalid slot`
-	errBroadcast         = `sending decision to replicas failed`
-	errRequestAcceptor   = `received non-2xx code for acceptor response`
-	errInvalidProposal   = `acceptor received an older proposal`
+	errRequestAcceptor    = `received non-2xx code for acceptor response`
+	errInvalidProposal    = `acceptor received an older proposal`
+	errLeaderIDOutOfRange = `leader id must fit in [0, maxLeaderID) to encode into a ballot`
+
+	// defaultMaxBatch bounds how many pending Propose calls are folded into a single Phase 2 round
+	defaultMaxBatch = 16
+
+	// defaultBatchWindow bounds how long a buffered request waits for the batch to fill up before it is
+	// committed on its own, so a low-traffic leader never leaves a caller waiting on an unfilled batch
+	defaultBatchWindow = 20 * time.Millisecond
+
+	// defaults for the per-phase timeouts, overridable via the exported Leader fields
+	defaultPrepareTimeout   = 2 * time.Second
+	defaultAcceptTimeout    = 2 * time.Second
+	defaultBroadcastTimeout = 2 * time.Second
 )
 
-type state struct {
-	id   int
-	slot int
-	val  string
+// ballot orders proposals by (round, leader_id) instead of the wall-clock timestamp used previously, so that
+// a leader can tell its own successive proposals apart from a competing leader's without relying on clock skew
+type ballot struct {
+	round    int
+	leaderID int
+}
+
+// greaterThan reports whether b should win over o when both are competing for the same slot
+func (b ballot) greaterThan(o ballot) bool {
+	if b.round != o.round {
+		return b.round > o.round
+	}
+	return b.leaderID > o.leaderID
+}
+
+// maxLeaderID bounds leaderID so that encode's fixed-width packing can never let a leader id bleed into the
+// round digits above it
+const maxLeaderID = 1_000_000
+
+// encode packs the ballot into the single int id that domain.Proposal carries over the wire. leaderID occupies a
+// fixed-width low end (see maxLeaderID) so that encoded ids sort the same way greaterThan does: round dominates,
+// and within a round a higher leaderID wins. A naive decimal concatenation (e.g. "%d%d") does not have this
+// property - ballot{round:2,leaderID:1}.encode() would sort below ballot{round:1,leaderID:10}.encode().
+func (b ballot) encode() (int, error) {
+	if b.leaderID < 0 || b.leaderID >= maxLeaderID {
+		return 0, logger.ErrorWithLine(errors.New(fmt.Sprintf(`%s (leaderID: %d)`, errLeaderIDOutOfRange, b.leaderID)))
+	}
+	return b.round*maxLeaderID + b.leaderID, nil
+}
+
+// noopLogger discards everything logged through it. It exists so a Leader built without a log.Logger wired in
+// still has a safe, non-nil default to call instead of panicking on a nil interface the first time it logs.
+type noopLogger struct{}
+
+func (noopLogger) Trace(...interface{}) {}
+func (noopLogger) Debug(...interface{}) {}
+func (noopLogger) Info(...interface{})  {}
+func (noopLogger) Warn(...interface{})  {}
+func (noopLogger) Error(...interface{}) {}
+func (noopLogger) Fatal(...interface{}) {}
+
+// promiseState is the highest proposal id this acceptor has promised for a given slot, and the value that went with it
+type promiseState struct {
+	id  int
+	val string
+}
+
+// acceptState is the proposal id (and value) this acceptor has accepted for a given slot
+type acceptState struct {
+	id  int
+	val string
 }
 
 type Leader struct {
 	id       int
 	lastSlot int
-	promised state
-	accepted state
-	replicas []string
-	leaders  []string // except this one
-	client   *http.Client
-	lock     *sync.Mutex
-	logger   log.Logger
+
+	// ballot is the round this leader currently holds; stable is true once a quorum has promised it, letting
+	// subsequent slots skip Phase 1 entirely and go straight to Phase 2 (the Multi-Paxos fast path)
+	ballot   ballot
+	stable   bool
+	MaxBatch int
+
+	// BatchWindow bounds how long a request sits in pending before it is committed on its own, in case traffic
+	// is too low to ever fill a batch to MaxBatch
+	BatchWindow time.Duration
+
+	// PrepareTimeout/AcceptTimeout bound how long send/sendBatch wait for a quorum of acceptors before giving up, and
+	// BroadcastTimeout bounds how long broadcastDecision waits for a single replica
+	PrepareTimeout   time.Duration
+	AcceptTimeout    time.Duration
+	BroadcastTimeout time.Duration
+
+	// nextSlot is the slot this leader will assign to the next buffered request; unlike lastSlot it advances the
+	// moment a request is buffered, not once it commits, so that two requests in the same batch never collide
+	// on a slot
+	nextSlot int
+
+	pending     []pendingEntry
+	pendingLock sync.Mutex
+	flushTimer  *time.Timer
+
+	// promised/accepted are keyed by slot id; a single shared pair cannot represent more than one slot at a time
+	promised map[int]promiseState
+	accepted map[int]acceptState
+
+	// highestPromised is the highest proposal id this acceptor has ever promised, for any slot. Multi-Paxos's
+	// whole point is that one Phase 1 covers every future slot, not just the one slot it happened to name, so a
+	// ballot promised here must fence out stale accepts for every slot - not just the per-slot entry in promised
+	highestPromised int
+
+	replicas  []string
+	leaders   []string // except this one
+	transport transport.Transport
+	lock      sync.Mutex
+	logger    log.Logger
+
+	// wal durably records every promised/accepted mutation so Recover can rebuild this acceptor's state after a crash
+	wal WAL
+
+	// decisions is the slot-indexed log of every decision this leader has finalized, served to lagging replicas
+	// through Catchup
+	decisions map[int]domain.Decision
 }
 
-func NewLeader() *Leader {
+// NewLeader wires up a Leader that reaches the rest of the cluster through t, leaving the choice of wire format
+// (HTTP/JSON, gRPC, or anything else implementing transport.Transport) entirely up to the caller.
+func NewLeader(t transport.Transport) *Leader {
 	return &Leader{
-		lastSlot: -1,
+		lastSlot:         -1,
+		nextSlot:         0,
+		MaxBatch:         defaultMaxBatch,
+		BatchWindow:      defaultBatchWindow,
+		PrepareTimeout:   defaultPrepareTimeout,
+		AcceptTimeout:    defaultAcceptTimeout,
+		BroadcastTimeout: defaultBroadcastTimeout,
+		transport:        t,
+		promised:         make(map[int]promiseState),
+		accepted:         make(map[int]acceptState),
+		decisions:        make(map[int]domain.Decision),
+		logger:           noopLogger{},
 	}
 }
 
-// Propose creates the proposal when a replica has requested this leader and carries out the consensus algorithm
-func (l *Leader) Propose(req domain.Request) (ok bool, err error) {
-	// return if the requested slot id is not for the next slot
-	if l.lastSlot+1 != req.SlotID {
-		return false, logger.ErrorWithLine(errors.New(fmt.Sprintf(`%s (slot: %d, requested: %d)`, errInvalidSlotLeader, l.lastSlot+1, req.SlotID)))
-	}
+// NewLeaderWithWAL is NewLeader with a WAL attached, so HandlePrepare/HandleAccept persist their state before
+// acknowledging a proposer
+func NewLeaderWithWAL(t transport.Transport, w WAL) *Leader {
+	l := NewLeader(t)
+	l.wal = w
+	return l
+}
 
-	prop, err := l.newProposal(req.SlotID, req.Val)
-	if err != nil {
-		return false, logger.ErrorWithLine(err)
+// Recover replays the WAL to rebuild promised, accepted and lastSlot after a restart. It must be called before the
+// leader starts handling any prepare/accept requests.
+func (l *Leader) Recover() error {
+	if l.wal == nil {
+		return nil
 	}
 
-	resList, err := l.send(typePrepare, prop)
+	records, err := l.wal.ReadAll()
 	if err != nil {
-		return false, logger.ErrorWithLine(err)
+		return logger.ErrorWithLine(err)
 	}
 
-	accepted, rejected, valid := l.validatePromises(resList)
-	if valid {
-		if accepted > rejected {
-			resList, err = l.send(typeAccept, prop)
-			if err != nil {
-				return false, logger.ErrorWithLine(err)
-			}
+	l.lock.Lock()
+	defer l.lock.Unlock()
 
-			accepted, rejected = l.validateAccepts(resList)
-			if accepted > rejected {
-				var dec domain.Decision
-				dec.SlotID = req.SlotID
-				dec.Val = req.Val
-				l.lastSlot++
-				err = l.broadcastDecision(dec, req.Replica)
-				if err != nil {
-					return false, logger.ErrorWithLine(err)
-				}
-				return true, nil
+	for _, rec := range records {
+		switch rec.Kind {
+		case recordKindPromise:
+			l.promised[rec.Slot] = promiseState{id: rec.ID, val: rec.Val}
+			if rec.ID > l.highestPromised {
+				l.highestPromised = rec.ID
+			}
+		case recordKindAccept:
+			l.accepted[rec.Slot] = acceptState{id: rec.ID, val: rec.Val}
+			if rec.Slot > l.lastSlot {
+				l.lastSlot = rec.Slot
 			}
 		}
 	}
+	l.nextSlot = l.lastSlot + 1
 
-	return false, nil
+	return nil
 }
 
-// newProposal creates a proposal with an id in the format of `timestamp`+`leader_id`
-func (l *Leader) newProposal(slotID int, val string) (domain.Proposal, error) {
-	ts := time.Now().Second()
-	pId, err := strconv.Atoi(fmt.Sprintf(`%d%d`, ts, l.id))
-	if err != nil {
-		return domain.Proposal{}, logger.ErrorWithLine(err)
+// pendingEntry pairs a buffered request with the channel its caller is waiting on, so Propose can block until the
+// request has actually gone through consensus instead of returning as soon as it's queued
+type pendingEntry struct {
+	req   domain.Request
+	resCh chan proposeResult
+}
+
+// proposeResult is what a buffered request resolves to once its batch has been committed (or has failed)
+type proposeResult struct {
+	ok  bool
+	err error
+}
+
+// Propose assigns req the next available slot, buffers it, and blocks until the batch it ends up in has actually
+// been committed (or failed). A batch is committed as soon as it reaches MaxBatch requests, or after BatchWindow
+// has passed since the first request was buffered, whichever comes first.
+func (l *Leader) Propose(req domain.Request) (ok bool, err error) {
+	resCh := make(chan proposeResult, 1)
+
+	l.pendingLock.Lock()
+	req.SlotID = l.nextSlot
+	l.nextSlot++
+	l.pending = append(l.pending, pendingEntry{req: req, resCh: resCh})
+	flush := len(l.pending) >= l.MaxBatch
+	var batch []pendingEntry
+	if flush {
+		batch = l.pending
+		l.pending = nil
+		if l.flushTimer != nil {
+			l.flushTimer.Stop()
+			l.flushTimer = nil
+		}
+	} else if l.flushTimer == nil {
+		l.flushTimer = time.AfterFunc(l.BatchWindow, l.flushPending)
 	}
+	l.pendingLock.Unlock()
 
-	return domain.Proposal{ID: pId, SlotID: slotID, Val: val}, nil
+	if flush {
+		l.commitBatch(batch)
+	}
+
+	res := <-resCh
+	return res.ok, res.err
 }
 
-// Broadcasts the decision to all the replicas excluding the requested one
-func (l *Leader) broadcastDecision(dec domain.Decision, requester string) error {
-	data, err := json.Marshal(dec)
-	if err != nil {
-		return logger.ErrorWithLine(err)
+// flushPending commits whatever has accumulated in pending once BatchWindow elapses without the batch reaching
+// MaxBatch, so a request never waits longer than BatchWindow for a batch that never fills up.
+func (l *Leader) flushPending() {
+	l.pendingLock.Lock()
+	batch := l.pending
+	l.pending = nil
+	l.flushTimer = nil
+	l.pendingLock.Unlock()
+
+	if len(batch) > 0 {
+		l.commitBatch(batch)
 	}
+}
 
-	for _, replica := range l.replicas {
-		if replica == requester {
-			continue
+// commitBatch drives the buffered requests through consensus and reports the outcome to each one's resCh. If this
+// leader hasn't already won Phase 1 for its current ballot, it first runs prepare against the next expected slot
+// to (re)establish leadership, then sends every request in the batch as a single Phase 2 round. Slots commit in
+// batch order and stop at the first rejected one, so lastSlot only ever advances through a contiguous run of
+// actually-decided slots - never past a gap. Every mutation of ballot/stable/lastSlot happens under l.lock so that
+// two batches committing concurrently (one flushed by a full batch, another by its BatchWindow timer) can never
+// race on this leader's shared state.
+func (l *Leader) commitBatch(batch []pendingEntry) {
+	ctx := context.Background()
+
+	l.lock.Lock()
+	stable := l.stable
+	if !stable {
+		l.ballot = ballot{round: l.ballot.round + 1, leaderID: l.id}
+	}
+	prepareSlot := l.lastSlot + 1
+	l.lock.Unlock()
+
+	if !stable {
+		prop, err := l.newProposal(prepareSlot, ``)
+		if err != nil {
+			failBatch(batch, logger.ErrorWithLine(err))
+			return
 		}
 
-		// todo can do in parallel
-		req, err := http.NewRequest(http.MethodPost, `http://`+replica+domain.UpdateReplicaEndpoint, bytes.NewBuffer(data))
+		resList, err := l.send(ctx, prop)
 		if err != nil {
-			return logger.ErrorWithLine(err)
+			failBatch(batch, logger.ErrorWithLine(err))
+			return
+		}
+
+		accepted, rejected, valid := l.validatePromises(resList)
+		if !valid || accepted <= rejected {
+			failBatch(batch, nil)
+			return
 		}
 
-		res, err := l.client.Do(req)
+		l.lock.Lock()
+		l.stable = true
+		l.lock.Unlock()
+	}
+
+	props := make([]domain.Proposal, len(batch))
+	for i, entry := range batch {
+		prop, err := l.newProposal(entry.req.SlotID, entry.req.Val)
 		if err != nil {
-			return logger.ErrorWithLine(err)
+			failBatch(batch, logger.ErrorWithLine(err))
+			return
+		}
+		props[i] = prop
+	}
+
+	resLists, err := l.sendBatch(ctx, props)
+	if err != nil {
+		failBatch(batch, logger.ErrorWithLine(err))
+		return
+	}
+
+	for i, entry := range batch {
+		accepted, rejected := l.validateAccepts(resLists[i])
+		if accepted <= rejected {
+			// an acceptor has promised a higher ballot in the meantime; fall back to prepare on the next round.
+			// Committing slots past this point would leave lastSlot skipping over this one's never-recorded
+			// decision - an undetectable hole in the log - so the rest of the batch fails along with it instead
+			// of being committed out of slot order; the caller is left to retry every failed request.
+			l.lock.Lock()
+			l.stable = false
+			l.lock.Unlock()
+			for _, rest := range batch[i:] {
+				rest.resCh <- proposeResult{ok: false}
+			}
+			return
 		}
 
-		if res.StatusCode != http.StatusOK {
-			res.Body.Close()
-			return logger.ErrorWithLine(errors.New(fmt.Sprintf(`%s (status: %d)`, errBroadcast, res.StatusCode)))
+		var dec domain.Decision
+		dec.SlotID = entry.req.SlotID
+		dec.Val = entry.req.Val
+
+		l.lock.Lock()
+		if dec.SlotID > l.lastSlot {
+			l.lastSlot = dec.SlotID
 		}
-		res.Body.Close()
+		l.decisions[dec.SlotID] = dec
+		l.lock.Unlock()
+
+		l.broadcastDecision(ctx, dec, entry.req.Replica)
+		entry.resCh <- proposeResult{ok: true}
 	}
+}
 
-	return nil
+// failBatch reports err (or a plain rejection if err is nil, e.g. a lost ballot race) to every request in batch
+func failBatch(batch []pendingEntry, err error) {
+	for _, entry := range batch {
+		entry.resCh <- proposeResult{err: err}
+	}
 }
 
-// Sends out the proposal to all acceptors in both phases prepare and accept, excluding the current leader as it does not exist in leader list
-func (l *Leader) send(typ string, prop domain.Proposal) ([]domain.Acceptance, error) {
-	data, err := json.Marshal(prop)
+// newProposal creates a proposal carrying this leader's current ballot
+func (l *Leader) newProposal(slotID int, val string) (domain.Proposal, error) {
+	pID, err := l.ballot.encode()
 	if err != nil {
-		return nil, logger.ErrorWithLine(err)
+		return domain.Proposal{}, logger.ErrorWithLine(err)
 	}
 
-	var endpoint string
-	if typ == typePrepare {
-		endpoint = domain.PrepareEndpoint
-	} else {
-		endpoint = domain.AcceptEndpoint
-	}
+	return domain.Proposal{ID: pID, SlotID: slotID, Val: val}, nil
+}
 
-	var resList []domain.Acceptance
-	for _, acceptor := range l.leaders {
-		// todo do this in parallel
-		req, err := http.NewRequest(http.MethodPost, `http://`+acceptor+endpoint, bytes.NewBuffer(data))
-		if err != nil {
-			return nil, logger.ErrorWithLine(err)
-		}
+// acceptorResult carries one acceptor's response (or the error from trying to reach it) back to the fan-out loop
+type acceptorResult struct {
+	acceptances []domain.Acceptance
+	err         error
+}
 
-		// todo majority is enough
-		res, err := l.client.Do(req)
-		if err != nil {
-			return nil, logger.ErrorWithLine(err)
+// Broadcasts the decision to all the replicas excluding the requested one. Replicas are dispatched to concurrently
+// through the configured Transport; ctx bounds the whole broadcast and BroadcastTimeout bounds each replica.
+func (l *Leader) broadcastDecision(ctx context.Context, dec domain.Decision, requester string) {
+	targets := make([]string, 0, len(l.replicas))
+	for _, replica := range l.replicas {
+		if replica != requester {
+			targets = append(targets, replica)
 		}
+	}
+
+	type broadcastResult struct {
+		replica string
+		err     error
+	}
 
-		if res.StatusCode != http.StatusOK {
-			res.Body.Close()
-			return nil, logger.ErrorWithLine(errors.New(fmt.Sprintf(`%s (type: %s, status: %d)`, errRequestAcceptor, typ, res.StatusCode)))
+	resCh := make(chan broadcastResult, len(targets))
+	for _, replica := range targets {
+		replica := replica
+		go func() {
+			rCtx, cancel := context.WithTimeout(ctx, l.BroadcastTimeout)
+			defer cancel()
+			resCh <- broadcastResult{replica: replica, err: l.transport.SendDecision(rCtx, replica, dec)}
+		}()
+	}
+
+	for range targets {
+		res := <-resCh
+		if res.err != nil {
+			// the replica missed this decision; rather than aborting the broadcast for the rest, it's left to pull
+			// the gap itself via Catchup, reconciled in the background by its own Learner
+			l.logger.Error(logger.ErrorWithLine(res.err))
 		}
+	}
+}
 
-		resData, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			res.Body.Close()
-			return nil, logger.ErrorWithLine(err)
+// Catchup returns every decision finalized for a slot at or after fromSlot, in slot order, so that a Learner
+// that missed a broadcast can fill in the gap itself instead of the leader having to retry the broadcast.
+func (l *Leader) Catchup(fromSlot int) ([]domain.Decision, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	n := l.lastSlot - fromSlot + 1
+	if n < 0 {
+		n = 0
+	}
+	decs := make([]domain.Decision, 0, n)
+	for slot := fromSlot; slot <= l.lastSlot; slot++ {
+		dec, ok := l.decisions[slot]
+		if !ok {
+			continue
 		}
-		res.Body.Close()
+		decs = append(decs, dec)
+	}
 
-		var response domain.Acceptance
-		err = json.Unmarshal(resData, &response)
-		if err != nil {
-			return nil, logger.ErrorWithLine(err)
+	return decs, nil
+}
+
+// Sends a single proposal to all acceptors concurrently through the configured Transport, used for the Phase 1
+// prepare round. It returns as soon as a majority has responded (Paxos only needs a quorum), cancelling the
+// remaining in-flight requests, or once PrepareTimeout elapses.
+func (l *Leader) send(ctx context.Context, prop domain.Proposal) ([]domain.Acceptance, error) {
+	ctx, cancel := context.WithTimeout(ctx, l.PrepareTimeout)
+	defer cancel()
+
+	resCh := make(chan acceptorResult, len(l.leaders))
+	for _, acceptor := range l.leaders {
+		acceptor := acceptor
+		go func() {
+			res, err := l.transport.SendPrepare(ctx, acceptor, prop)
+			if err != nil {
+				resCh <- acceptorResult{err: logger.ErrorWithLine(err)}
+				return
+			}
+			resCh <- acceptorResult{acceptances: []domain.Acceptance{res}}
+		}()
+	}
+
+	quorum := len(l.leaders)/2 + 1
+	var resList []domain.Acceptance
+	for i := 0; i < len(l.leaders); i++ {
+		select {
+		case r := <-resCh:
+			if r.err == nil {
+				resList = append(resList, r.acceptances...)
+				if len(resList) >= quorum {
+					return resList, nil
+				}
+			}
+		case <-ctx.Done():
+			return resList, nil
 		}
-		resList = append(resList, response)
 	}
 
 	return resList, nil
 }
 
+// sendBatch ships a whole batch of proposals to each acceptor in one Transport call, used for the Phase 2 accept
+// round so that a full batch only costs one round trip per acceptor instead of one per proposal. Acceptors are
+// dispatched to concurrently and sendBatch returns once a majority has replied, cancelling the rest. The result is
+// transposed so that result[i] holds every responding acceptor's answer to props[i], ready for validateAccepts.
+func (l *Leader) sendBatch(ctx context.Context, props []domain.Proposal) ([][]domain.Acceptance, error) {
+	ctx, cancel := context.WithTimeout(ctx, l.AcceptTimeout)
+	defer cancel()
+
+	resCh := make(chan acceptorResult, len(l.leaders))
+	for _, acceptor := range l.leaders {
+		acceptor := acceptor
+		go func() {
+			res, err := l.transport.SendAccept(ctx, acceptor, props)
+			if err != nil {
+				resCh <- acceptorResult{err: logger.ErrorWithLine(err)}
+				return
+			}
+			resCh <- acceptorResult{acceptances: res}
+		}()
+	}
+
+	quorum := len(l.leaders)/2 + 1
+	result := make([][]domain.Acceptance, len(props))
+	responded := 0
+	for i := 0; i < len(l.leaders); i++ {
+		select {
+		case r := <-resCh:
+			if r.err != nil {
+				continue
+			}
+			if len(r.acceptances) != len(props) {
+				// a malformed or stale response; this acceptor can't be trusted to line up with props, so skip it
+				// rather than misaligning or indexing out of range
+				continue
+			}
+			for j, response := range r.acceptances {
+				result[j] = append(result[j], response)
+			}
+			responded++
+			if responded >= quorum {
+				return result, nil
+			}
+		case <-ctx.Done():
+			return result, nil
+		}
+	}
+
+	return result, nil
+}
+
 // Validates promises upon receiving them from acceptors and returns number of accepted and rejected cases. This function
 // returns false for valid if a different proposer has already started a proposal with a higher id.
 func (l *Leader) validatePromises(resList []domain.Acceptance) (accepted, rejected int, valid bool) {
@@ -235,69 +565,86 @@ func (l *Leader) HandlePrepare(prop domain.Proposal) (domain.Acceptance, error)
 	defer l.lock.Unlock()
 
 	// returns an error if the proposal is for an older slot
-	if l.accepted.slot > prop.SlotID {
-		return domain.Acceptance{}, logger.ErrorWithLine(errors.New(fmt.Sprintf(`%s (phase: %s, last: %d, requested: %d)`,
-			errInvalidProposal, typePrepare, l.accepted.slot, prop.SlotID)))
-	}
-
-	if l.promised.slot == prop.SlotID {
-		// check if promised id is higher than the requested one since proposer will use this to terminate its proposal
-		if l.promised.id >= prop.ID {
-			res.PrvPromise.Exists = true
-			res.PrvPromise.ID = l.promised.id
-			res.PrvPromise.Val = l.promised.val
-		} else {
-			// as the requested prepare is valid, acceptor updates its state for the same slot
-			l.promised.id = prop.ID
-			l.promised.val = prop.Val
-		}
+	if accepted, ok := l.accepted[prop.SlotID]; ok && accepted.id > prop.ID {
+		return domain.Acceptance{}, logger.ErrorWithLine(errors.New(fmt.Sprintf(`%s (phase: %s, slot: %d)`,
+			errInvalidProposal, typePrepare, prop.SlotID)))
+	}
+
+	// a prepare in Multi-Paxos stands for every future slot, not just prop.SlotID, so the NACK/promote decision is
+	// made against the highest ballot ever promised by this acceptor, not just whatever (if anything) is on file
+	// for this particular slot
+	if l.highestPromised >= prop.ID {
+		res.PrvPromise.Exists = true
+		res.PrvPromise.ID = l.highestPromised
 	} else {
-		// if the prepare request is for a new slot
-		l.promised.id = prop.ID
-		l.promised.slot = prop.SlotID
-		l.promised.val = prop.Val
+		if err := l.persistPromise(prop); err != nil {
+			return domain.Acceptance{}, logger.ErrorWithLine(err)
+		}
 	}
 
 	// if there's an already accepted proposal for the same slot, acceptor just notifies the proposer
-	if l.accepted.slot == prop.SlotID && l.accepted.id != 0 {
+	if accepted, ok := l.accepted[prop.SlotID]; ok {
 		res.PrvAccept.Exists = true
-		res.PrvAccept.ID = l.accepted.id
-		res.PrvAccept.Val = l.accepted.val
+		res.PrvAccept.ID = accepted.id
+		res.PrvAccept.Val = accepted.val
 	}
 
 	return res, nil
 }
 
-// HandleAccept checks if it can accept the confirmation request from a proposer
-func (l *Leader) HandleAccept(prop domain.Proposal) (domain.Acceptance, error) {
-	// returns an error if the proposal is for an older slot
-	if l.accepted.slot > prop.SlotID {
-		return domain.Acceptance{}, logger.ErrorWithLine(errors.New(fmt.Sprintf(`%s (phase: %s, last: %d, requested: %d)`,
-			errInvalidProposal, typeAccept, l.accepted.slot, prop.SlotID)))
+// persistPromise records the promise in the WAL (if one is attached) before updating in-memory state, so a crash
+// between the two can never leave an acknowledged promise unrecoverable
+func (l *Leader) persistPromise(prop domain.Proposal) error {
+	if l.wal != nil {
+		if err := l.wal.Append(walRecord{Kind: recordKindPromise, Slot: prop.SlotID, ID: prop.ID, Val: prop.Val}); err != nil {
+			return errors.New(fmt.Sprintf(`%s: %s`, errWALWrite, err))
+		}
+	}
+	l.promised[prop.SlotID] = promiseState{id: prop.ID, val: prop.Val}
+	if prop.ID > l.highestPromised {
+		l.highestPromised = prop.ID
 	}
+	return nil
+}
 
-	var res domain.Acceptance
-	res.PID = prop.ID
+// HandleAccept checks if it can accept the confirmation request for every proposal in the batch, one slot at a time
+func (l *Leader) HandleAccept(props []domain.Proposal) ([]domain.Acceptance, error) {
 	l.lock.Lock()
 	defer l.lock.Unlock()
 
-	// rejects if already promised to a proposal with a higher id for the same slot
-	if l.promised.slot == prop.SlotID && l.promised.id >= prop.ID {
-		res.Accepted = false
-		return res, nil
-	}
+	res := make([]domain.Acceptance, len(props))
+	for i, prop := range props {
+		var r domain.Acceptance
+		r.PID = prop.ID
 
-	// rejects if already accepted for the same slot
-	if l.accepted.slot == prop.SlotID && l.accepted.id != 0 {
-		res.Accepted = false
-		return res, nil
-	}
+		// fails the whole batch with an error if a newer proposal has already been accepted for the same slot
+		if accepted, ok := l.accepted[prop.SlotID]; ok && accepted.id > prop.ID {
+			return nil, logger.ErrorWithLine(errors.New(fmt.Sprintf(`%s (phase: %s, slot: %d)`,
+				errInvalidProposal, typeAccept, prop.SlotID)))
+		}
 
-	l.accepted.id = prop.ID
-	l.accepted.val = prop.Val
-	l.accepted.slot = prop.SlotID
-	l.lastSlot++
-	res.Accepted = true
+		// rejects if a higher ballot has been promised, for this slot or any other: a promise covers every future
+		// slot in Multi-Paxos, so a slot that never received its own prepare is still fenced by a promise made
+		// for a different slot under the same (or a later) ballot
+		if prop.ID < l.highestPromised {
+			r.Accepted = false
+			res[i] = r
+			continue
+		}
+
+		if l.wal != nil {
+			if err := l.wal.Append(walRecord{Kind: recordKindAccept, Slot: prop.SlotID, ID: prop.ID, Val: prop.Val}); err != nil {
+				return nil, logger.ErrorWithLine(errors.New(fmt.Sprintf(`%s: %s`, errWALWrite, err)))
+			}
+		}
+
+		l.accepted[prop.SlotID] = acceptState{id: prop.ID, val: prop.Val}
+		if prop.SlotID > l.lastSlot {
+			l.lastSlot = prop.SlotID
+		}
+		r.Accepted = true
+		res[i] = r
+	}
 
 	return res, nil
 }
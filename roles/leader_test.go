@@ -0,0 +1,212 @@
+package roles
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-paxos/domain"
+)
+
+// fakeTransport always accepts every prepare/accept/decision it's sent, standing in for a healthy cluster of
+// acceptors/replicas in tests that only care about the Leader's own bookkeeping
+type fakeTransport struct{}
+
+func (fakeTransport) SendPrepare(_ context.Context, _ string, prop domain.Proposal) (domain.Acceptance, error) {
+	return domain.Acceptance{PID: prop.ID, Accepted: true}, nil
+}
+
+func (fakeTransport) SendAccept(_ context.Context, _ string, props []domain.Proposal) ([]domain.Acceptance, error) {
+	res := make([]domain.Acceptance, len(props))
+	for i, prop := range props {
+		res[i] = domain.Acceptance{PID: prop.ID, Accepted: true}
+	}
+	return res, nil
+}
+
+func (fakeTransport) SendDecision(_ context.Context, _ string, _ domain.Decision) error {
+	return nil
+}
+
+func (fakeTransport) FetchLastSlot(_ context.Context, _ string) (int, error) {
+	return -1, nil
+}
+
+func (fakeTransport) FetchCatchup(_ context.Context, _ string, _ int) ([]domain.Decision, error) {
+	return nil, nil
+}
+
+// failingDecisionTransport is fakeTransport except SendDecision always fails, standing in for a replica that's
+// crashed or partitioned away.
+type failingDecisionTransport struct {
+	fakeTransport
+}
+
+func (failingDecisionTransport) SendDecision(_ context.Context, _ string, _ domain.Decision) error {
+	return errors.New("replica unreachable")
+}
+
+// rejectingAcceptTransport is fakeTransport except SendAccept rejects whichever slots are listed in rejectSlots,
+// standing in for an acceptor that has moved on to a competing ballot for those slots.
+type rejectingAcceptTransport struct {
+	fakeTransport
+	rejectSlots map[int]bool
+}
+
+func (r rejectingAcceptTransport) SendAccept(_ context.Context, _ string, props []domain.Proposal) ([]domain.Acceptance, error) {
+	res := make([]domain.Acceptance, len(props))
+	for i, prop := range props {
+		res[i] = domain.Acceptance{PID: prop.ID, Accepted: !r.rejectSlots[prop.SlotID]}
+	}
+	return res, nil
+}
+
+func TestLeaderCommitBatch_StopsAtFirstRejectedSlotInBatch(t *testing.T) {
+	l := NewLeader(rejectingAcceptTransport{rejectSlots: map[int]bool{1: true}})
+	l.leaders = []string{"acceptor-1", "acceptor-2", "acceptor-3"}
+	l.replicas = []string{"replica-1"}
+
+	batch := make([]pendingEntry, 3)
+	resChs := make([]chan proposeResult, 3)
+	for i := range batch {
+		resChs[i] = make(chan proposeResult, 1)
+		batch[i] = pendingEntry{req: domain.Request{SlotID: i, Val: "v"}, resCh: resChs[i]}
+	}
+
+	l.commitBatch(batch)
+
+	if res := <-resChs[0]; !res.ok {
+		t.Fatalf("expected slot 0 to commit, got %+v", res)
+	}
+	if res := <-resChs[1]; res.ok {
+		t.Fatal("expected slot 1 (rejected by the acceptor) to fail")
+	}
+	if res := <-resChs[2]; res.ok {
+		t.Fatal("expected slot 2 to fail along with slot 1 instead of committing past the gap")
+	}
+
+	if l.lastSlot != 0 {
+		t.Fatalf("expected lastSlot to stop at 0, got %d", l.lastSlot)
+	}
+	if len(l.decisions) != 1 {
+		t.Fatalf("expected only slot 0 to be recorded as decided, got %d decisions", len(l.decisions))
+	}
+	if _, ok := l.decisions[2]; ok {
+		t.Fatal("slot 2 must not be recorded as decided while slot 1 is missing")
+	}
+}
+
+func TestLeaderBroadcastDecision_DoesNotPanicOnReplicaFailure(t *testing.T) {
+	l := NewLeader(failingDecisionTransport{})
+	l.replicas = []string{"replica-1", "replica-2"}
+	l.BroadcastTimeout = time.Second
+
+	// must not panic: l.logger was never explicitly set, and broadcastDecision logs every SendDecision failure
+	l.broadcastDecision(context.Background(), domain.Decision{SlotID: 0, Val: "v"}, "requester")
+}
+
+func TestLeaderHandleAccept_RejectsStaleBallotForSlotNeverPrepared(t *testing.T) {
+	l := NewLeader(fakeTransport{})
+
+	// promise a high ballot for slot 10
+	if _, err := l.HandlePrepare(domain.Proposal{ID: 5_000_000, SlotID: 10}); err != nil {
+		t.Fatalf("HandlePrepare returned an error: %v", err)
+	}
+
+	// a stale, lower-ballot accept for an entirely different slot must still be rejected: the promise for slot 10
+	// fences every slot under this ballot, not just slot 10
+	res, err := l.HandleAccept([]domain.Proposal{{ID: 1_000_000, SlotID: 11, Val: "x"}})
+	if err != nil {
+		t.Fatalf("HandleAccept returned an error: %v", err)
+	}
+	if res[0].Accepted {
+		t.Fatal("expected the stale-ballot accept for an unprepared slot to be rejected")
+	}
+}
+
+func TestBallotEncodeOrdering(t *testing.T) {
+	lower := ballot{round: 1, leaderID: 10}
+	higher := ballot{round: 2, leaderID: 1}
+
+	lowerID, err := lower.encode()
+	if err != nil {
+		t.Fatalf("lower.encode() returned an error: %v", err)
+	}
+
+	higherID, err := higher.encode()
+	if err != nil {
+		t.Fatalf("higher.encode() returned an error: %v", err)
+	}
+
+	if !higher.greaterThan(lower) {
+		t.Fatalf("expected %+v to be greater than %+v", higher, lower)
+	}
+
+	if higherID <= lowerID {
+		t.Fatalf("encode() does not preserve ballot ordering: higher=%+v encoded to %d, lower=%+v encoded to %d",
+			higher, higherID, lower, lowerID)
+	}
+}
+
+func TestBallotEncodeRejectsOutOfRangeLeaderID(t *testing.T) {
+	if _, err := (ballot{round: 1, leaderID: maxLeaderID}).encode(); err == nil {
+		t.Fatal("expected encode() to reject a leaderID at maxLeaderID")
+	}
+}
+
+func newTestLeader() *Leader {
+	l := NewLeader(fakeTransport{})
+	l.leaders = []string{"acceptor-1", "acceptor-2", "acceptor-3"}
+	l.replicas = []string{"replica-1", "replica-2"}
+	l.BatchWindow = 10 * time.Millisecond
+	return l
+}
+
+func TestLeaderPropose_CommitsBelowMaxBatchViaWindow(t *testing.T) {
+	l := newTestLeader()
+	l.MaxBatch = 10 // never reached by the single request below
+
+	ok, err := l.Propose(domain.Request{Val: "a"})
+	if err != nil {
+		t.Fatalf("Propose returned an error: %v", err)
+	}
+	if !ok {
+		t.Fatal("expected Propose to report the request as committed")
+	}
+}
+
+func TestLeaderPropose_AssignsDistinctSequentialSlots(t *testing.T) {
+	l := newTestLeader()
+	l.MaxBatch = 100 // force every request below to flush via BatchWindow, not a full batch
+
+	const n = 5
+	var wg sync.WaitGroup
+	oks := make([]bool, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			oks[i], errs[i] = l.Propose(domain.Request{Val: "v"})
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			t.Fatalf("request %d returned an error: %v", i, errs[i])
+		}
+		if !oks[i] {
+			t.Fatalf("request %d was not committed", i)
+		}
+	}
+
+	if l.lastSlot != n-1 {
+		t.Fatalf("expected lastSlot to advance to %d after %d committed requests, got %d", n-1, n, l.lastSlot)
+	}
+	if len(l.decisions) != n {
+		t.Fatalf("expected %d decisions to be recorded, got %d", n, len(l.decisions))
+	}
+}
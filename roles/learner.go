@@ -0,0 +1,145 @@
+package roles
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-paxos/domain"
+	"github.com/go-paxos/logger"
+	"github.com/go-paxos/transport"
+)
+
+const (
+	// defaultReconcileInterval is how often a Learner probes its peers for their last decided slot
+	defaultReconcileInterval = 5 * time.Second
+)
+
+// Learner subscribes to decisions broadcast by a Leader and keeps its own slot-indexed log up to date. Unlike an
+// acceptor, a Learner never votes on proposals; it only ever catches up on what has already been decided, which is
+// what lets it recover from a missed broadcast without involving the leader's own Phase 2 path.
+type Learner struct {
+	transport transport.Transport
+	peers     []string
+
+	// ReconcileInterval controls how often reconcileOnce runs once StartReconciling has been called
+	ReconcileInterval time.Duration
+
+	lock      sync.Mutex
+	decisions map[int]domain.Decision
+	lastSlot  int
+
+	stop chan struct{}
+}
+
+// NewLearner wires up a Learner that reaches the rest of the cluster through t and reconciles against peers.
+func NewLearner(t transport.Transport, peers []string) *Learner {
+	return &Learner{
+		transport:         t,
+		peers:             peers,
+		ReconcileInterval: defaultReconcileInterval,
+		decisions:         make(map[int]domain.Decision),
+		lastSlot:          -1,
+	}
+}
+
+// HandleDecision records a decision broadcast directly to this Learner
+func (ln *Learner) HandleDecision(dec domain.Decision) error {
+	ln.lock.Lock()
+	defer ln.lock.Unlock()
+
+	ln.decisions[dec.SlotID] = dec
+	if dec.SlotID > ln.lastSlot {
+		ln.lastSlot = dec.SlotID
+	}
+
+	return nil
+}
+
+// Catchup returns every decision this Learner holds from fromSlot onward, in slot order, serving the same role
+// for a peer that Leader.Catchup serves for the leader itself.
+func (ln *Learner) Catchup(fromSlot int) ([]domain.Decision, error) {
+	ln.lock.Lock()
+	defer ln.lock.Unlock()
+
+	n := ln.lastSlot - fromSlot + 1
+	if n < 0 {
+		n = 0
+	}
+	decs := make([]domain.Decision, 0, n)
+	for slot := fromSlot; slot <= ln.lastSlot; slot++ {
+		dec, ok := ln.decisions[slot]
+		if !ok {
+			continue
+		}
+		decs = append(decs, dec)
+	}
+
+	return decs, nil
+}
+
+// StartReconciling launches the background goroutine that periodically probes peers for how far ahead they are
+// and pulls any gap in this Learner's own log. It returns immediately; call Stop to shut the goroutine down.
+func (ln *Learner) StartReconciling() {
+	ln.stop = make(chan struct{})
+	go ln.reconcileLoop()
+}
+
+// Stop shuts down the goroutine started by StartReconciling
+func (ln *Learner) Stop() {
+	if ln.stop != nil {
+		close(ln.stop)
+	}
+}
+
+func (ln *Learner) reconcileLoop() {
+	ticker := time.NewTicker(ln.ReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ln.reconcileOnce()
+		case <-ln.stop:
+			return
+		}
+	}
+}
+
+// reconcileOnce probes every peer for its last decided slot and, if any peer is ahead of this Learner, pulls the
+// missing decisions from it. Peers are tried in order and the first one that is ahead and answers the catch-up
+// request is used; if none does, reconciliation simply runs again next tick.
+func (ln *Learner) reconcileOnce() {
+	ctx, cancel := context.WithTimeout(context.Background(), ln.ReconcileInterval)
+	defer cancel()
+
+	ln.lock.Lock()
+	fromSlot := ln.lastSlot + 1
+	ln.lock.Unlock()
+
+	for _, peer := range ln.peers {
+		peerLastSlot, err := ln.transport.FetchLastSlot(ctx, peer)
+		if err != nil {
+			continue
+		}
+		if peerLastSlot < fromSlot {
+			continue
+		}
+
+		decs, err := ln.transport.FetchCatchup(ctx, peer, fromSlot)
+		if err != nil {
+			logger.ErrorWithLine(err)
+			continue
+		}
+
+		ln.lock.Lock()
+		for _, dec := range decs {
+			ln.decisions[dec.SlotID] = dec
+			if dec.SlotID > ln.lastSlot {
+				ln.lastSlot = dec.SlotID
+			}
+		}
+		ln.lock.Unlock()
+		return
+	}
+}
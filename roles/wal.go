@@ -0,0 +1,158 @@
+package roles
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/go-paxos/logger"
+)
+
+const (
+	// recordKindPromise/recordKindAccept tag which map a replayed walRecord belongs to
+	recordKindPromise = `promise`
+	recordKindAccept  = `accept`
+
+	errWALWrite    = `failed to append record to the write-ahead log`
+	errWALRead     = `failed to read record from the write-ahead log`
+	errWALChecksum = `write-ahead log record failed its crc check`
+)
+
+// walRecord is the durable representation of a single promised/accepted mutation, replayed in order by Recover
+type walRecord struct {
+	Kind string `json:"kind"`
+	Slot int    `json:"slot"`
+	ID   int    `json:"id"`
+	Val  string `json:"val"`
+}
+
+// WAL is the durability boundary for an acceptor's promised/accepted state: HandlePrepare and HandleAccept must
+// append a record here before responding to the proposer, or a restart can silently forget a promise and let two
+// proposers both believe they won the same slot.
+type WAL interface {
+	// Append persists rec and only returns once it is durable
+	Append(rec walRecord) error
+	// ReadAll returns every record written so far, in the order they were appended
+	ReadAll() ([]walRecord, error)
+}
+
+// FileWAL is a WAL backed by length-prefixed, CRC-checked JSON records appended to a single file on disk
+type FileWAL struct {
+	file *os.File
+	lock sync.Mutex
+}
+
+// NewFileWAL opens (creating if necessary) the WAL file at path for appending and later replay
+func NewFileWAL(path string) (*FileWAL, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, logger.ErrorWithLine(err)
+	}
+
+	return &FileWAL{file: f}, nil
+}
+
+// Append writes rec as a length-prefixed, CRC32-checksummed JSON frame and fsyncs before returning
+func (w *FileWAL) Append(rec walRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return logger.ErrorWithLine(err)
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	var frame bytes.Buffer
+	if err := binary.Write(&frame, binary.BigEndian, uint32(len(data))); err != nil {
+		return logger.ErrorWithLine(err)
+	}
+	if err := binary.Write(&frame, binary.BigEndian, crc32.ChecksumIEEE(data)); err != nil {
+		return logger.ErrorWithLine(err)
+	}
+	frame.Write(data)
+
+	if _, err := w.file.Write(frame.Bytes()); err != nil {
+		return logger.ErrorWithLine(err)
+	}
+
+	return w.file.Sync()
+}
+
+// ReadAll rewinds the WAL file and decodes every frame in it, verifying each record's checksum along the way
+func (w *FileWAL) ReadAll() ([]walRecord, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return nil, logger.ErrorWithLine(err)
+	}
+
+	var records []walRecord
+	r := bufio.NewReader(w.file)
+	for {
+		var size uint32
+		var checksum uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, logger.ErrorWithLine(err)
+		}
+		if err := binary.Read(r, binary.BigEndian, &checksum); err != nil {
+			return nil, logger.ErrorWithLine(fmt.Errorf(`%s: %w`, errWALRead, err))
+		}
+
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, logger.ErrorWithLine(fmt.Errorf(`%s: %w`, errWALRead, err))
+		}
+
+		if crc32.ChecksumIEEE(data) != checksum {
+			return nil, logger.ErrorWithLine(errors.New(errWALChecksum))
+		}
+
+		var rec walRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, logger.ErrorWithLine(err)
+		}
+		records = append(records, rec)
+	}
+
+	if _, err := w.file.Seek(0, io.SeekEnd); err != nil {
+		return nil, logger.ErrorWithLine(err)
+	}
+
+	return records, nil
+}
+
+// MemoryWAL is an in-memory WAL, useful for tests and for acceptors that don't need crash durability
+type MemoryWAL struct {
+	records []walRecord
+	lock    sync.Mutex
+}
+
+func NewMemoryWAL() *MemoryWAL {
+	return &MemoryWAL{}
+}
+
+func (w *MemoryWAL) Append(rec walRecord) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.records = append(w.records, rec)
+	return nil
+}
+
+func (w *MemoryWAL) ReadAll() ([]walRecord, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	out := make([]walRecord, len(w.records))
+	copy(out, w.records)
+	return out, nil
+}
@@ -0,0 +1,99 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/go-paxos/domain"
+	"github.com/go-paxos/logger"
+)
+
+const errNonOKResponse = `received non-2xx code for transport request`
+
+// HTTP is the original JSON-over-HTTP Transport: every call marshals its payload and POSTs it to the matching
+// domain endpoint on the target replica.
+type HTTP struct {
+	client *http.Client
+}
+
+func NewHTTP(client *http.Client) *HTTP {
+	return &HTTP{client: client}
+}
+
+func (t *HTTP) SendPrepare(ctx context.Context, acceptor string, prop domain.Proposal) (domain.Acceptance, error) {
+	var res domain.Acceptance
+	if err := t.roundTrip(ctx, acceptor, domain.PrepareEndpoint, prop, &res); err != nil {
+		return domain.Acceptance{}, logger.ErrorWithLine(err)
+	}
+	return res, nil
+}
+
+func (t *HTTP) SendAccept(ctx context.Context, acceptor string, props []domain.Proposal) ([]domain.Acceptance, error) {
+	var res []domain.Acceptance
+	if err := t.roundTrip(ctx, acceptor, domain.AcceptEndpoint, props, &res); err != nil {
+		return nil, logger.ErrorWithLine(err)
+	}
+	return res, nil
+}
+
+func (t *HTTP) SendDecision(ctx context.Context, replica string, dec domain.Decision) error {
+	if err := t.roundTrip(ctx, replica, domain.UpdateReplicaEndpoint, dec, nil); err != nil {
+		return logger.ErrorWithLine(err)
+	}
+	return nil
+}
+
+func (t *HTTP) FetchLastSlot(ctx context.Context, peer string) (int, error) {
+	var res domain.LastSlot
+	if err := t.roundTrip(ctx, peer, domain.LastSlotEndpoint, nil, &res); err != nil {
+		return 0, logger.ErrorWithLine(err)
+	}
+	return res.SlotID, nil
+}
+
+func (t *HTTP) FetchCatchup(ctx context.Context, peer string, fromSlot int) ([]domain.Decision, error) {
+	var res []domain.Decision
+	if err := t.roundTrip(ctx, peer, domain.CatchupEndpoint, domain.CatchupRequest{FromSlot: fromSlot}, &res); err != nil {
+		return nil, logger.ErrorWithLine(err)
+	}
+	return res, nil
+}
+
+// roundTrip POSTs body as JSON to endpoint on target and, if out is non-nil, decodes the JSON response into it
+func (t *HTTP) roundTrip(ctx context.Context, target, endpoint string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return logger.ErrorWithLine(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, `http://`+target+endpoint, bytes.NewBuffer(data))
+	if err != nil {
+		return logger.ErrorWithLine(err)
+	}
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return logger.ErrorWithLine(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return logger.ErrorWithLine(errors.New(fmt.Sprintf(`%s (endpoint: %s, status: %d)`, errNonOKResponse, endpoint, res.StatusCode)))
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	resData, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return logger.ErrorWithLine(err)
+	}
+
+	return json.Unmarshal(resData, out)
+}
@@ -0,0 +1,25 @@
+// Package transport decouples the roles package from any one RPC mechanism: roles drives the Paxos state
+// machine and calls out through Transport, leaving the wire format and endpoint layout entirely up to the
+// implementation in use.
+package transport
+
+import (
+	"context"
+
+	"github.com/go-paxos/domain"
+)
+
+// Transport is how a proposer reaches the other replicas in the cluster. Implementations own their own endpoint
+// or method naming; callers only ever deal in domain types.
+type Transport interface {
+	// SendPrepare carries out the Phase 1 prepare round against a single acceptor
+	SendPrepare(ctx context.Context, acceptor string, prop domain.Proposal) (domain.Acceptance, error)
+	// SendAccept carries out the Phase 2 accept round against a single acceptor for a whole batch of proposals
+	SendAccept(ctx context.Context, acceptor string, props []domain.Proposal) ([]domain.Acceptance, error)
+	// SendDecision notifies a replica of a finalized decision
+	SendDecision(ctx context.Context, replica string, dec domain.Decision) error
+	// FetchLastSlot asks peer for the highest slot it has decided, used by a Learner to detect how far it has fallen behind
+	FetchLastSlot(ctx context.Context, peer string) (int, error)
+	// FetchCatchup asks peer for every decision it holds from fromSlot onward, used by a Learner to fill in a gap
+	FetchCatchup(ctx context.Context, peer string, fromSlot int) ([]domain.Decision, error)
+}